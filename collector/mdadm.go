@@ -15,18 +15,34 @@ import (
 )
 
 var (
-	statusfile   = "/proc/mdstat"
-	statuslineRE = regexp.MustCompile(`(\d+) blocks .*\[(\d+)/(\d+)\] \[[U_]+\]`)
-	buildlineRE  = regexp.MustCompile(`\((\d+)/\d+\)`)
+	statusfile        = "/proc/mdstat"
+	statuslineRE      = regexp.MustCompile(`(\d+) blocks .*\[(\d+)/(\d+)\] \[[U_]+\]`)
+	buildlineRE       = regexp.MustCompile(`\((\d+)/\d+\)`)
+	speedRE           = regexp.MustCompile(`speed=(\d+\.?\d*)([KM])?/sec`)
+	finishRE          = regexp.MustCompile(`finish=(\d+\.?\d*)min`)
+	componentMarkerRE = regexp.MustCompile(`\(([A-Z])\)$`)
 )
 
-type mdStatus struct {
-	mdName       string
-	isActive     bool
-	disksActive  int64
-	disksTotal   int64
-	blocksTotal  int64
-	blocksSynced int64
+// MDStat holds the parsed state of a single md-device. The field names
+// mirror those used by github.com/prometheus/procfs so that this collector
+// can eventually be switched over to the shared library without changing
+// the exported metric names.
+type MDStat struct {
+	Name             string
+	ActivityState    string
+	Level            string
+	DisksActive      int64
+	DisksTotal       int64
+	DisksFailed      int64
+	DisksSpare       int64
+	DisksJournal     int64
+	DisksWriteMostly int64
+	BlocksTotal      int64
+	BlocksSynced     int64
+	BlocksToBeSynced int64
+	SyncAction       string
+	SyncSpeed        float64
+	SyncFinish       float64
 }
 
 type mdadmCollector struct{}
@@ -86,32 +102,114 @@ func evalBuildline(buildline string) (int64, error) {
 	return syncedSize, nil
 }
 
+// Gets the sync speed in bytes/sec and the estimated time to finish in
+// seconds out of the sync-line. Either value is 0 if not present on the line.
+func evalSpeedline(buildline string) (speed, finish float64, err error) {
+	if matches := speedRE.FindStringSubmatch(buildline); matches != nil {
+		speed, err = strconv.ParseFloat(matches[1], 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s in buildline: %s", err, buildline)
+		}
+
+		switch matches[2] {
+		case "K":
+			speed *= 1024
+		case "M":
+			speed *= 1024 * 1024
+		}
+	}
+
+	if matches := finishRE.FindStringSubmatch(buildline); matches != nil {
+		finishMinutes, err := strconv.ParseFloat(matches[1], 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%s in buildline: %s", err, buildline)
+		}
+		finish = finishMinutes * 60
+	}
+
+	return speed, finish, nil
+}
+
+// isSyncLine reports whether a block-line describes an in-progress sync
+// action, and if so which one.
+func isSyncLine(line string) (syncAction string, ok bool) {
+	switch {
+	case strings.Contains(line, "recovery"):
+		return "recover", true
+	case strings.Contains(line, "reshape"):
+		return "reshape", true
+	case strings.Contains(line, "repair"):
+		return "repair", true
+	case strings.Contains(line, "check"):
+		return "check", true
+	case strings.Contains(line, "resync"):
+		return "resync", true
+	}
+	return "", false
+}
+
+var syncActionToState = map[string]string{
+	"recover": "recovering",
+	"reshape": "reshaping",
+	"repair":  "repairing",
+	"check":   "checking",
+	"resync":  "resyncing",
+}
+
+// classifyComponents counts the per-disk role markers found in the trailing
+// component-device list of an mdstat header line: sdc1[1](F) is failed,
+// sdd1[2](S) is spare, sde1[3](J) is journal and sdf1[4](W) is write-mostly.
+// Components with no marker are regular, in-sync members and aren't counted
+// here.
+func classifyComponents(tokens []string) (failed, spare, journal, writeMostly int64) {
+	for _, t := range tokens {
+		matches := componentMarkerRE.FindStringSubmatch(t)
+		if matches == nil {
+			continue
+		}
+
+		switch matches[1] {
+		case "F":
+			failed++
+		case "S":
+			spare++
+		case "J":
+			journal++
+		case "W":
+			writeMostly++
+		}
+	}
+
+	return failed, spare, journal, writeMostly
+}
+
 // Parses an mdstat-file and returns a struct with the relevant infos.
-func parseMdstat(mdStatusFilePath string) ([]mdStatus, error) {
+func parseMdstat(mdStatusFilePath string) ([]MDStat, error) {
 	content, err := ioutil.ReadFile(mdStatusFilePath)
 	if err != nil {
-		return []mdStatus{}, fmt.Errorf("error parsing %s: %s", statusfile, err)
+		return []MDStat{}, fmt.Errorf("error parsing %s: %s", statusfile, err)
 	}
 
 	mdStatusFile := string(content)
 
 	lines := strings.Split(mdStatusFile, "\n")
-	var currentMD string
 
 	// Each md has at least the deviceline, statusline and one empty line afterwards
 	// so we will have probably something of the order len(lines)/3 devices
 	// so we use that for preallocation.
 	estimateMDs := len(lines) / 3
-	mdStates := make([]mdStatus, 0, estimateMDs)
+	mdStates := make([]MDStat, 0, estimateMDs)
 
-	for i, l := range lines {
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
 		if l == "" {
 			// Skip entirely empty lines.
 			continue
 		}
 
 		if l[0] == ' ' {
-			// Those lines are not the beginning of a md-section.
+			// Those lines are not the beginning of a md-section; they are
+			// picked up as part of the block scan below.
 			continue
 		}
 
@@ -120,38 +218,104 @@ func parseMdstat(mdStatusFilePath string) ([]mdStatus, error) {
 			continue
 		}
 
-		mainLine := strings.Split(l, " ")
+		mainLine := strings.Fields(l)
 		if len(mainLine) < 3 {
 			return mdStates, fmt.Errorf("error parsing mdline: %s", l)
 		}
-		currentMD = mainLine[0]               // name of md-device
-		isActive := (mainLine[2] == "active") // activity status of said md-device
+		currentMD := mainLine[0] // name of md-device
+		isActive := mainLine[2] == "active"
+		if isActive && len(mainLine) < 4 {
+			return mdStates, fmt.Errorf("error parsing mdline: %s", l)
+		}
 
-		if len(lines) <= i+3 {
-			return mdStates, fmt.Errorf("error parsing %s: entry for %s has fewer lines than expected", statusfile, currentMD)
+		// Walk the rest of the block (everything up to the next blank line)
+		// and classify each line by keyword instead of by its fixed offset,
+		// so wrapped component lists, reordered bitmap lines and delayed
+		// resyncs are all handled the same way.
+		var statusline, syncline string
+		var componentLines []string
+		j := i + 1
+		for ; j < len(lines) && lines[j] != ""; j++ {
+			trimmed := strings.TrimSpace(lines[j])
+			switch {
+			case strings.Contains(trimmed, "blocks"):
+				statusline = trimmed
+			case strings.HasPrefix(trimmed, "bitmap"):
+				// Not used yet, just recognised so it isn't mistaken for a
+				// wrapped component line.
+			case strings.HasPrefix(trimmed, "resync=DELAYED"):
+				syncline = trimmed
+			default:
+				if _, ok := isSyncLine(trimmed); ok {
+					syncline = trimmed
+				} else {
+					// Continuation of a component-device list that wrapped
+					// onto its own line.
+					componentLines = append(componentLines, trimmed)
+				}
+			}
+		}
+		i = j - 1
+
+		// The component-device list starts right after the personality
+		// (active arrays only) or right after the state (inactive arrays,
+		// which have no personality), and may continue onto wrapped lines.
+		componentsStart := 3
+		if isActive {
+			componentsStart = 4
+		}
+		componentTokens := append(append([]string{}, mainLine[componentsStart:]...), strings.Fields(strings.Join(componentLines, " "))...)
+		failed, spare, journal, writeMostly := classifyComponents(componentTokens)
+
+		if !isActive {
+			// Arrays that are not active (e.g. "inactive") don't carry a
+			// meaningful statusline, so don't bother trying to parse one -
+			// derive the disk count from the component-device list instead.
+			// Inactive arrays have no personality, so there is no level to report.
+			mdStates = append(mdStates, MDStat{
+				Name:             currentMD,
+				ActivityState:    "inactive",
+				DisksTotal:       int64(len(componentTokens)),
+				DisksFailed:      failed,
+				DisksSpare:       spare,
+				DisksJournal:     journal,
+				DisksWriteMostly: writeMostly,
+				SyncAction:       "idle",
+			})
+			continue
 		}
 
-		active, total, size, err := evalStatusline(lines[i+1]) // parse statusline, always present
+		level := mainLine[3] // raid level, e.g. raid1, raid5, linear
+
+		if statusline == "" {
+			return mdStates, fmt.Errorf("error parsing %s: entry for %s has no statusline", statusfile, currentMD)
+		}
 
+		active, total, size, err := evalStatusline(statusline)
 		if err != nil {
 			return mdStates, fmt.Errorf("error parsing %s: %s", statusfile, err)
 		}
 
-		// Now get the number of synced blocks.
+		// If device is syncing, checking, reshaping or repairing at the moment, get
+		// the number of currently synced blocks and the sync speed/ETA. A delayed
+		// resync has synced no blocks yet. Otherwise the number of synced blocks
+		// equals the size of the device and there is no speed to report.
+		activityState := "active"
+		syncAction := "idle"
 		var syncedBlocks int64
+		var syncSpeed, syncFinish float64
 
-		// Get the line number of the syncing-line.
-		var j int
-		if strings.Contains(lines[i+2], "bitmap") { // then skip the bitmap line
-			j = i + 3
-		} else {
-			j = i + 2
-		}
+		if strings.HasPrefix(syncline, "resync=DELAYED") {
+			activityState, syncAction = "resyncing", "resync"
+		} else if action, ok := isSyncLine(syncline); ok {
+			activityState, syncAction = syncActionToState[action], action
+
+			syncedBlocks, err = evalBuildline(syncline)
+			if err != nil {
+				return mdStates, fmt.Errorf("error parsing %s: %s", statusfile, err)
+			}
 
-		// If device is syncing at the moment, get the number of currently synced bytes,
-		// otherwise that number equals the size of the device.
-		if strings.Contains(lines[j], "recovery") || strings.Contains(lines[j], "resync") {
-			syncedBlocks, err = evalBuildline(lines[j])
+			syncSpeed, syncFinish, err = evalSpeedline(syncline)
 			if err != nil {
 				return mdStates, fmt.Errorf("error parsing %s: %s", statusfile, err)
 			}
@@ -159,8 +323,23 @@ func parseMdstat(mdStatusFilePath string) ([]mdStatus, error) {
 			syncedBlocks = size
 		}
 
-		mdStates = append(mdStates, mdStatus{currentMD, isActive, active, total, size, syncedBlocks})
-
+		mdStates = append(mdStates, MDStat{
+			Name:             currentMD,
+			ActivityState:    activityState,
+			Level:            level,
+			DisksActive:      active,
+			DisksTotal:       total,
+			DisksFailed:      failed,
+			DisksSpare:       spare,
+			DisksJournal:     journal,
+			DisksWriteMostly: writeMostly,
+			BlocksTotal:      size,
+			BlocksSynced:     syncedBlocks,
+			BlocksToBeSynced: size - syncedBlocks,
+			SyncAction:       syncAction,
+			SyncSpeed:        syncSpeed,
+			SyncFinish:       syncFinish,
+		})
 	}
 
 	return mdStates, nil
@@ -171,38 +350,83 @@ func NewMdadmCollector() (Collector, error) {
 	return &mdadmCollector{}, nil
 }
 
+// Possible values for the state label of node_md_state.
+var possibleActivityStates = []string{"active", "inactive", "recovering", "resyncing", "checking", "reshaping", "repairing"}
+
 var (
-	isActiveDesc = prometheus.NewDesc(
-		prometheus.BuildFQName(Namespace, "md", "is_active"),
-		"Indicator whether the md-device is active or not.",
-		[]string{"device"},
+	stateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "md", "state"),
+		"Indicator whether the md-device is in a certain state.",
+		[]string{"device", "level", "state"},
 		nil,
 	)
 
 	disksActiveDesc = prometheus.NewDesc(
 		prometheus.BuildFQName(Namespace, "md", "disks_active"),
 		"Number of active disks of device.",
-		[]string{"device"},
+		[]string{"device", "level"},
+		nil,
+	)
+
+	disksFailedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "md", "disks_failed"),
+		"Number of failed disks of device.",
+		[]string{"device", "level"},
+		nil,
+	)
+
+	disksSpareDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "md", "disks_spare"),
+		"Number of spare disks of device.",
+		[]string{"device", "level"},
+		nil,
+	)
+
+	disksJournalDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "md", "disks_journal"),
+		"Number of journal disks of device.",
+		[]string{"device", "level"},
+		nil,
+	)
+
+	disksWriteMostlyDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "md", "disks_writemostly"),
+		"Number of write-mostly disks of device.",
+		[]string{"device", "level"},
 		nil,
 	)
 
 	disksTotalDesc = prometheus.NewDesc(
 		prometheus.BuildFQName(Namespace, "md", "disks"),
 		"Total number of disks of device.",
-		[]string{"device"},
+		[]string{"device", "level"},
 		nil,
 	)
 
 	blocksTotalDesc = prometheus.NewDesc(
 		prometheus.BuildFQName(Namespace, "md", "blocks"),
 		"Total number of blocks on device.",
-		[]string{"device"},
+		[]string{"device", "level"},
 		nil,
 	)
 
 	blocksSyncedDesc = prometheus.NewDesc(
 		prometheus.BuildFQName(Namespace, "md", "blocks_synced"),
 		"Number of blocks synced on device.",
+		[]string{"device", "level", "sync_action"},
+		nil,
+	)
+
+	syncSpeedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "md", "sync_speed_bytes_per_second"),
+		"Rate of current sync operation on device, zero if no sync in progress.",
+		[]string{"device"},
+		nil,
+	)
+
+	syncFinishDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "md", "sync_estimated_finish_seconds"),
+		"Estimated time to finish the current sync operation on device, zero if no sync in progress.",
 		[]string{"device"},
 		nil,
 	)
@@ -227,50 +451,102 @@ func (c *mdadmCollector) Update(ch chan<- prometheus.Metric) (err error) {
 	}
 
 	// ... and then plug the result into the metrics to be exported.
-	var isActiveFloat float64
 	for _, mds := range mdstate {
 
-		log.Debugf("collecting metrics for device %s", mds.mdName)
+		log.Debugf("collecting metrics for device %s", mds.Name)
 
-		if mds.isActive {
-			isActiveFloat = 1
-		} else {
-			isActiveFloat = 0
+		for _, state := range possibleActivityStates {
+			var stateFloat float64
+			if state == mds.ActivityState {
+				stateFloat = 1
+			}
+			ch <- prometheus.MustNewConstMetric(
+				stateDesc,
+				prometheus.GaugeValue,
+				stateFloat,
+				mds.Name,
+				mds.Level,
+				state,
+			)
 		}
 
 		ch <- prometheus.MustNewConstMetric(
-			isActiveDesc,
+			disksActiveDesc,
 			prometheus.GaugeValue,
-			isActiveFloat,
-			mds.mdName,
+			float64(mds.DisksActive),
+			mds.Name,
+			mds.Level,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
-			disksActiveDesc,
+			disksFailedDesc,
+			prometheus.GaugeValue,
+			float64(mds.DisksFailed),
+			mds.Name,
+			mds.Level,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			disksSpareDesc,
+			prometheus.GaugeValue,
+			float64(mds.DisksSpare),
+			mds.Name,
+			mds.Level,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			disksJournalDesc,
+			prometheus.GaugeValue,
+			float64(mds.DisksJournal),
+			mds.Name,
+			mds.Level,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			disksWriteMostlyDesc,
 			prometheus.GaugeValue,
-			float64(mds.disksActive),
-			mds.mdName,
+			float64(mds.DisksWriteMostly),
+			mds.Name,
+			mds.Level,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			disksTotalDesc,
 			prometheus.GaugeValue,
-			float64(mds.disksTotal),
-			mds.mdName,
+			float64(mds.DisksTotal),
+			mds.Name,
+			mds.Level,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			blocksTotalDesc,
 			prometheus.GaugeValue,
-			float64(mds.blocksTotal),
-			mds.mdName,
+			float64(mds.BlocksTotal),
+			mds.Name,
+			mds.Level,
 		)
 
 		ch <- prometheus.MustNewConstMetric(
 			blocksSyncedDesc,
 			prometheus.GaugeValue,
-			float64(mds.blocksSynced),
-			mds.mdName,
+			float64(mds.BlocksSynced),
+			mds.Name,
+			mds.Level,
+			mds.SyncAction,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			syncSpeedDesc,
+			prometheus.GaugeValue,
+			mds.SyncSpeed,
+			mds.Name,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			syncFinishDesc,
+			prometheus.GaugeValue,
+			mds.SyncFinish,
+			mds.Name,
 		)
 
 	}
@@ -0,0 +1,307 @@
+// +build !nomdadm
+
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeMdstat(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "mdstat")
+	if err != nil {
+		t.Fatalf("failed to create temp mdstat file: %s", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("failed to write temp mdstat file: %s", err)
+	}
+
+	return f.Name()
+}
+
+func TestParseMdstat(t *testing.T) {
+	tests := []struct {
+		name     string
+		mdstat   string
+		expected []MDStat
+	}{
+		{
+			name: "active array",
+			mdstat: `Personalities : [raid1]
+md0 : active raid1 sdb1[1] sda1[0]
+      136448 blocks [2/2] [UU]
+
+unused devices: <none>
+`,
+			expected: []MDStat{
+				{
+					Name:          "md0",
+					ActivityState: "active",
+					Level:         "raid1",
+					DisksActive:   2,
+					DisksTotal:    2,
+					BlocksTotal:   136448,
+					BlocksSynced:  136448,
+					SyncAction:    "idle",
+				},
+			},
+		},
+		{
+			name: "inactive array",
+			mdstat: `Personalities : [raid1]
+md0 : inactive sda1[0] sdb1[1]
+      665600 blocks super 1.2
+
+unused devices: <none>
+`,
+			expected: []MDStat{
+				{
+					Name:          "md0",
+					ActivityState: "inactive",
+					DisksTotal:    2,
+					SyncAction:    "idle",
+				},
+			},
+		},
+		{
+			name: "check in progress",
+			mdstat: `Personalities : [raid5]
+md0 : active raid5 sdb1[1] sdc1[2] sdd1[3]
+      1953486080 blocks super 1.2 level 5, 512k chunk, algorithm 2 [3/3] [UUU]
+      [=====>...............]  check = 25.0% (244260352/976743040) finish=90.0min speed=54000K/sec
+
+unused devices: <none>
+`,
+			expected: []MDStat{
+				{
+					Name:             "md0",
+					ActivityState:    "checking",
+					Level:            "raid5",
+					DisksActive:      3,
+					DisksTotal:       3,
+					BlocksTotal:      1953486080,
+					BlocksSynced:     244260352,
+					BlocksToBeSynced: 1953486080 - 244260352,
+					SyncAction:       "check",
+					SyncSpeed:        54000 * 1024,
+					SyncFinish:       90 * 60,
+				},
+			},
+		},
+		{
+			name: "reshape in progress",
+			mdstat: `Personalities : [raid5]
+md0 : active raid5 sdb1[1] sdc1[2] sdd1[3]
+      1953486080 blocks super 1.2 level 5, 512k chunk, algorithm 2 [3/3] [UUU]
+      [=====>...............]  reshape = 25.0% (244260352/976743040) finish=90.0min speed=54000K/sec
+
+unused devices: <none>
+`,
+			expected: []MDStat{
+				{
+					Name:             "md0",
+					ActivityState:    "reshaping",
+					Level:            "raid5",
+					DisksActive:      3,
+					DisksTotal:       3,
+					BlocksTotal:      1953486080,
+					BlocksSynced:     244260352,
+					BlocksToBeSynced: 1953486080 - 244260352,
+					SyncAction:       "reshape",
+					SyncSpeed:        54000 * 1024,
+					SyncFinish:       90 * 60,
+				},
+			},
+		},
+		{
+			name: "repair in progress",
+			mdstat: `Personalities : [raid5]
+md0 : active raid5 sdb1[1] sdc1[2] sdd1[3]
+      1953486080 blocks super 1.2 level 5, 512k chunk, algorithm 2 [3/3] [UUU]
+      [=====>...............]  repair = 25.0% (244260352/976743040) finish=90.0min speed=54000K/sec
+
+unused devices: <none>
+`,
+			expected: []MDStat{
+				{
+					Name:             "md0",
+					ActivityState:    "repairing",
+					Level:            "raid5",
+					DisksActive:      3,
+					DisksTotal:       3,
+					BlocksTotal:      1953486080,
+					BlocksSynced:     244260352,
+					BlocksToBeSynced: 1953486080 - 244260352,
+					SyncAction:       "repair",
+					SyncSpeed:        54000 * 1024,
+					SyncFinish:       90 * 60,
+				},
+			},
+		},
+		{
+			name: "wrapped component list",
+			mdstat: `Personalities : [raid6]
+md0 : active raid6 sdb1[1] sdc1[2] sdd1[3] sde1[4]
+      sdf1[5] sdg1[6]
+      976631808 blocks super 1.2 level 6, 512k chunk, algorithm 2 [6/6] [UUUUUU]
+
+unused devices: <none>
+`,
+			expected: []MDStat{
+				{
+					Name:          "md0",
+					ActivityState: "active",
+					Level:         "raid6",
+					DisksActive:   6,
+					DisksTotal:    6,
+					BlocksTotal:   976631808,
+					BlocksSynced:  976631808,
+					SyncAction:    "idle",
+				},
+			},
+		},
+		{
+			name: "delayed resync",
+			mdstat: `Personalities : [raid1]
+md0 : active raid1 sdb1[1] sda1[0]
+      976631808 blocks super 1.2 [2/2] [UU]
+      resync=DELAYED
+
+unused devices: <none>
+`,
+			expected: []MDStat{
+				{
+					Name:             "md0",
+					ActivityState:    "resyncing",
+					Level:            "raid1",
+					DisksActive:      2,
+					DisksTotal:       2,
+					BlocksTotal:      976631808,
+					BlocksToBeSynced: 976631808,
+					SyncAction:       "resync",
+				},
+			},
+		},
+		{
+			name: "failed, spare, journal and write-mostly markers",
+			mdstat: `Personalities : [raid5]
+md0 : active raid5 sdb1[1](F) sdc1[2](S) sdd1[3](J) sde1[4](W) sdf1[0]
+      1953486080 blocks super 1.2 level 5, 512k chunk, algorithm 2 [3/2] [U_U]
+
+unused devices: <none>
+`,
+			expected: []MDStat{
+				{
+					Name:             "md0",
+					ActivityState:    "active",
+					Level:            "raid5",
+					DisksActive:      2,
+					DisksTotal:       3,
+					DisksFailed:      1,
+					DisksSpare:       1,
+					DisksJournal:     1,
+					DisksWriteMostly: 1,
+					BlocksTotal:      1953486080,
+					BlocksSynced:     1953486080,
+					SyncAction:       "idle",
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeMdstat(t, test.mdstat)
+			defer os.Remove(path)
+
+			got, err := parseMdstat(path)
+			if err != nil {
+				t.Fatalf("unexpected error parsing %s: %s", filepath.Base(path), err)
+			}
+
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("got %+v, want %+v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseMdstatTruncatedActiveHeader(t *testing.T) {
+	path := writeMdstat(t, `Personalities : [raid1]
+md0 : active
+
+unused devices: <none>
+`)
+	defer os.Remove(path)
+
+	if _, err := parseMdstat(path); err == nil {
+		t.Fatal("expected an error for a truncated active-device header line, got none")
+	}
+}
+
+func TestClassifyComponents(t *testing.T) {
+	tests := []struct {
+		name            string
+		tokens          []string
+		wantFailed      int64
+		wantSpare       int64
+		wantJournal     int64
+		wantWriteMostly int64
+	}{
+		{
+			name:   "no markers",
+			tokens: []string{"sda1[0]", "sdb1[1]"},
+		},
+		{
+			name:            "one of each marker",
+			tokens:          []string{"sda1[0](F)", "sdb1[1](S)", "sdc1[2](J)", "sdd1[3](W)"},
+			wantFailed:      1,
+			wantSpare:       1,
+			wantJournal:     1,
+			wantWriteMostly: 1,
+		},
+		{
+			name:       "repeated marker",
+			tokens:     []string{"sda1[0](F)", "sdb1[1](F)"},
+			wantFailed: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			failed, spare, journal, writeMostly := classifyComponents(test.tokens)
+			if failed != test.wantFailed || spare != test.wantSpare || journal != test.wantJournal || writeMostly != test.wantWriteMostly {
+				t.Errorf("classifyComponents(%v) = (%d, %d, %d, %d), want (%d, %d, %d, %d)",
+					test.tokens, failed, spare, journal, writeMostly,
+					test.wantFailed, test.wantSpare, test.wantJournal, test.wantWriteMostly)
+			}
+		})
+	}
+}
+
+func TestIsSyncLine(t *testing.T) {
+	tests := []struct {
+		line       string
+		wantAction string
+		wantOK     bool
+	}{
+		{line: "[==>..] recovery = 12.6% (39354368/312319552) finish=39.7min speed=114112K/sec", wantAction: "recover", wantOK: true},
+		{line: "[==>..] reshape = 12.6% (39354368/312319552) finish=39.7min speed=114112K/sec", wantAction: "reshape", wantOK: true},
+		{line: "[==>..] check = 12.6% (39354368/312319552) finish=39.7min speed=114112K/sec", wantAction: "check", wantOK: true},
+		{line: "[==>..] repair = 12.6% (39354368/312319552) finish=39.7min speed=114112K/sec", wantAction: "repair", wantOK: true},
+		{line: "[==>..] resync = 12.6% (39354368/312319552) finish=39.7min speed=114112K/sec", wantAction: "resync", wantOK: true},
+		{line: "976631808 blocks super 1.2 [2/2] [UU]", wantAction: "", wantOK: false},
+	}
+
+	for _, test := range tests {
+		action, ok := isSyncLine(test.line)
+		if action != test.wantAction || ok != test.wantOK {
+			t.Errorf("isSyncLine(%q) = (%q, %v), want (%q, %v)", test.line, action, ok, test.wantAction, test.wantOK)
+		}
+	}
+}